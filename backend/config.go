@@ -0,0 +1,342 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+// runtimeConfig is the fully resolved, typed configuration for the process.
+// Consumers that live for more than one call should hold a *configStore and
+// call Load() each time rather than caching a runtimeConfig, since Watch can
+// swap it out from under them.
+type runtimeConfig struct {
+	HTTP    httpConfig
+	DB      dbConfig
+	ESI     esiConfig
+	Session sessionConfig
+	Admin   adminConfig
+	Log     logConfig
+
+	migrateDown string
+}
+
+type httpConfig struct {
+	Port           string
+	RequestTimeout time.Duration
+}
+
+type dbConfig struct {
+	User string
+	Pass string
+	Host string
+	Port string
+	Name string
+}
+
+type esiConfig struct {
+	AppId       string
+	AppSecret   string
+	AppRedirect string
+	JwtSkew     time.Duration
+	CallTimeout time.Duration
+}
+
+type sessionConfig struct {
+	Store    sessionStoreKind
+	HashKey  string
+	BlockKey string
+}
+
+type adminConfig struct {
+	Character int32
+}
+
+type logConfig struct {
+	Environment string
+}
+
+var (
+	errInvalidJwtSkew        = errors.New("jwtSkew must be positive")
+	errInvalidAppRedirect    = errors.New("appRedirect must be a valid URL")
+	errInvalidHTTPReqTimeout = errors.New("http.requestTimeout must be positive")
+	errInvalidESICallTimeout = errors.New("esi.callTimeout must be positive")
+)
+
+// configStore holds the current runtimeConfig behind an atomic.Pointer so
+// Watch can swap in a freshly loaded config without callers needing a lock or
+// a restart.
+type configStore struct {
+	current atomic.Pointer[runtimeConfig]
+
+	// configFile is the config.{yaml,toml,...} path resolved on the first
+	// load, if any, so Watch can fsnotify it without touching the viper
+	// package-level singleton (loadConfig never touches that singleton).
+	configFile string
+}
+
+// newConfigStore loads the initial runtimeConfig and returns a store ready to
+// be handed to Watch.
+func newConfigStore() (*configStore, error) {
+	cfg, configFile, err := loadConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	s := &configStore{configFile: configFile}
+	s.current.Store(cfg)
+	return s, nil
+}
+
+// Load returns the current runtimeConfig. The returned value is a point in
+// time snapshot; call Load again to observe later changes.
+func (s *configStore) Load() *runtimeConfig {
+	return s.current.Load()
+}
+
+// Watch re-parses the config on SIGHUP or on a write to the config file, and
+// atomically swaps the result into the store so operators can rotate
+// ESI_APP_SECRET or change AdminCharacter without a restart. The logger is
+// built once at startup from log.environment, so a reloaded log setting does
+// not change the log level. It blocks until stop is closed, so callers should
+// run it in its own goroutine.
+func (s *configStore) Watch(stop <-chan struct{}, logger *zap.Logger) {
+	sighup := make(chan os.Signal, 1)
+	signal.Notify(sighup, syscall.SIGHUP)
+	defer signal.Stop(sighup)
+
+	fileChanged := make(chan struct{}, 1)
+	if s.configFile != "" {
+		if watcher, err := fsnotify.NewWatcher(); err == nil {
+			defer watcher.Close()
+			if err := watcher.Add(s.configFile); err == nil {
+				go func() {
+					for range watcher.Events {
+						select {
+						case fileChanged <- struct{}{}:
+						default:
+						}
+					}
+				}()
+			}
+		}
+	}
+
+	reload := func() {
+		cfg, _, err := loadConfig()
+		if err != nil {
+			logger.Error("failed to reload config", zap.Error(err))
+			return
+		}
+		s.current.Store(cfg)
+		logger.Info("config reloaded")
+	}
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-sighup:
+			reload()
+		case <-fileChanged:
+			reload()
+		}
+	}
+}
+
+// loadConfig resolves the runtimeConfig with viper-style precedence: built-in
+// defaults, then config.yaml/config.toml, then ./.env, then real environment
+// variables, then CLI flags. It returns the config file path it resolved (if
+// any) so Watch can fsnotify it without reaching for the viper package-level
+// singleton, which loadConfig never touches.
+func loadConfig() (*runtimeConfig, string, error) {
+	v := viper.New()
+
+	v.SetDefault("http.port", "2727")
+	v.SetDefault("http.requestTimeout", "30s")
+	v.SetDefault("db.host", "localhost")
+	v.SetDefault("db.port", "3308")
+	v.SetDefault("db.user", "local")
+	v.SetDefault("db.pass", "local")
+	v.SetDefault("db.name", "local")
+	v.SetDefault("esi.jwtSkew", "5m")
+	v.SetDefault("esi.callTimeout", "10s")
+	v.SetDefault("session.store", string(sessionStoreFilesystem))
+	v.SetDefault("readOnly", false)
+
+	v.SetConfigName("config")
+	v.AddConfigPath(".")
+	if err := v.ReadInConfig(); err != nil {
+		var notFound viper.ConfigFileNotFoundError
+		if !errors.As(err, &notFound) {
+			return nil, "", err
+		}
+	}
+
+	// dotenv.AllSettings() returns flat, lowercased keys (e.g. "http_port"),
+	// not the dotted nested keys ("http.port") the rest of this file reads,
+	// so merging it into v directly would be silently ignored. Populate the
+	// process environment instead (without clobbering a real env var that's
+	// already set), which is exactly what the existing bindEnv calls below
+	// already read from.
+	dotenv := viper.New()
+	dotenv.SetConfigFile("./.env")
+	dotenv.SetConfigType("env")
+	if err := dotenv.ReadInConfig(); err == nil {
+		for key, val := range dotenv.AllSettings() {
+			envKey := strings.ToUpper(key)
+			if _, ok := os.LookupEnv(envKey); !ok {
+				os.Setenv(envKey, fmt.Sprintf("%v", val))
+			}
+		}
+	}
+
+	bindEnv(v, "http.port", envHttpPort)
+	bindEnv(v, "http.requesttimeout", envHttpRequestTimeout)
+	bindEnv(v, "esi.appid", envAppId)
+	bindEnv(v, "esi.appsecret", envAppSecret)
+	bindEnv(v, "esi.appredirect", envAppRedirect)
+	bindEnv(v, "esi.jwtskew", envJwtSkew)
+	bindEnv(v, "esi.calltimeout", envEsiCallTimeout)
+	bindEnv(v, "db.user", envDbUser)
+	bindEnv(v, "db.pass", envDbPass)
+	bindEnv(v, "db.host", envDbHost)
+	bindEnv(v, "db.port", envDbPort)
+	bindEnv(v, "db.name", envDbName)
+	bindEnv(v, "session.store", envSessionStore)
+	bindEnv(v, "session.hashkey", envSessionHashKey)
+	bindEnv(v, "session.blockkey", envSessionBlockKey)
+	bindEnv(v, "log.environment", envEnvironment)
+	bindEnv(v, "readonly", envReadOnly)
+	bindEnv(v, "migratedown", envMigrateDown)
+
+	if err := v.BindPFlags(configFlags()); err != nil {
+		return nil, "", err
+	}
+
+	jwtSkew, err := time.ParseDuration(v.GetString("esi.jwtskew"))
+	if err != nil {
+		return nil, "", fmt.Errorf("error parsing jwt skew: %w", err)
+	}
+
+	requestTimeout, err := time.ParseDuration(v.GetString("http.requesttimeout"))
+	if err != nil {
+		return nil, "", fmt.Errorf("error parsing http request timeout: %w", err)
+	}
+
+	esiCallTimeout, err := time.ParseDuration(v.GetString("esi.calltimeout"))
+	if err != nil {
+		return nil, "", fmt.Errorf("error parsing esi call timeout: %w", err)
+	}
+
+	cfg := &runtimeConfig{
+		HTTP: httpConfig{
+			Port:           v.GetString("http.port"),
+			RequestTimeout: requestTimeout,
+		},
+		DB: dbConfig{
+			User: v.GetString("db.user"),
+			Pass: v.GetString("db.pass"),
+			Host: v.GetString("db.host"),
+			Port: v.GetString("db.port"),
+			Name: v.GetString("db.name"),
+		},
+		ESI: esiConfig{
+			AppId:       v.GetString("esi.appid"),
+			AppSecret:   v.GetString("esi.appsecret"),
+			AppRedirect: v.GetString("esi.appredirect"),
+			JwtSkew:     jwtSkew,
+			CallTimeout: esiCallTimeout,
+		},
+		Session: sessionConfig{
+			Store:    sessionStoreKind(v.GetString("session.store")),
+			HashKey:  v.GetString("session.hashkey"),
+			BlockKey: v.GetString("session.blockkey"),
+		},
+		Admin: adminConfig{
+			Character: v.GetInt32("admin.character"),
+		},
+		Log:         logConfig{Environment: v.GetString("log.environment")},
+		migrateDown: v.GetString("migratedown"),
+	}
+
+	if err := cfg.validate(); err != nil {
+		return nil, "", err
+	}
+
+	setReadOnly(v.GetBool("readonly"))
+
+	return cfg, v.ConfigFileUsed(), nil
+}
+
+func bindEnv(v *viper.Viper, key, env string) {
+	_ = v.BindEnv(key, env)
+}
+
+var (
+	configFlagsOnce sync.Once
+	configFlagSet   *pflag.FlagSet
+)
+
+// configFlags defines the CLI flags that sit at the top of loadConfig's
+// precedence chain, parses them from os.Args once, and returns the shared
+// FlagSet for viper to bind against. Defaults mirror the v.SetDefault calls
+// in loadConfig, so an unchanged flag resolves to the same value as the
+// built-in default.
+func configFlags() *pflag.FlagSet {
+	configFlagsOnce.Do(func() {
+		fs := pflag.NewFlagSet("brave-bpc", pflag.ContinueOnError)
+		fs.String("http.port", "2727", "HTTP listen port")
+		fs.String("http.requesttimeout", "30s", "HTTP request timeout")
+		fs.String("db.host", "localhost", "database host")
+		fs.String("db.port", "3308", "database port")
+		fs.String("db.user", "local", "database user")
+		fs.String("db.pass", "local", "database password")
+		fs.String("db.name", "local", "database name")
+		fs.String("esi.appid", "", "ESI application id")
+		fs.String("esi.appsecret", "", "ESI application secret")
+		fs.String("esi.appredirect", "", "ESI application redirect URL")
+		fs.String("esi.jwtskew", "5m", "JWT clock skew tolerance")
+		fs.String("esi.calltimeout", "10s", "ESI call timeout")
+		fs.String("session.store", string(sessionStoreFilesystem), "session store kind")
+		fs.String("log.environment", "", "log environment")
+		fs.Bool("readonly", false, "start in read-only mode")
+		_ = fs.Parse(os.Args[1:])
+		configFlagSet = fs
+	})
+	return configFlagSet
+}
+
+func (cfg *runtimeConfig) validate() error {
+	if cfg.ESI.JwtSkew <= 0 {
+		return errInvalidJwtSkew
+	}
+
+	if cfg.HTTP.RequestTimeout <= 0 {
+		return errInvalidHTTPReqTimeout
+	}
+
+	if cfg.ESI.CallTimeout <= 0 {
+		return errInvalidESICallTimeout
+	}
+
+	if cfg.ESI.AppRedirect != "" {
+		if _, err := url.ParseRequestURI(cfg.ESI.AppRedirect); err != nil {
+			return errors.Join(errInvalidAppRedirect, err)
+		}
+	}
+
+	return nil
+}
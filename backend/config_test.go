@@ -0,0 +1,65 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func validRuntimeConfig() *runtimeConfig {
+	return &runtimeConfig{
+		HTTP: httpConfig{RequestTimeout: 30 * time.Second},
+		ESI:  esiConfig{JwtSkew: 5 * time.Minute, CallTimeout: 10 * time.Second},
+	}
+}
+
+func TestRuntimeConfigValidateOK(t *testing.T) {
+	if err := validRuntimeConfig().validate(); err != nil {
+		t.Fatalf("validate() = %v, want nil", err)
+	}
+}
+
+func TestRuntimeConfigValidateInvalidJwtSkew(t *testing.T) {
+	cfg := validRuntimeConfig()
+	cfg.ESI.JwtSkew = 0
+
+	if err := cfg.validate(); !errors.Is(err, errInvalidJwtSkew) {
+		t.Fatalf("validate() = %v, want %v", err, errInvalidJwtSkew)
+	}
+}
+
+func TestRuntimeConfigValidateInvalidHTTPRequestTimeout(t *testing.T) {
+	cfg := validRuntimeConfig()
+	cfg.HTTP.RequestTimeout = -1
+
+	if err := cfg.validate(); !errors.Is(err, errInvalidHTTPReqTimeout) {
+		t.Fatalf("validate() = %v, want %v", err, errInvalidHTTPReqTimeout)
+	}
+}
+
+func TestRuntimeConfigValidateInvalidESICallTimeout(t *testing.T) {
+	cfg := validRuntimeConfig()
+	cfg.ESI.CallTimeout = 0
+
+	if err := cfg.validate(); !errors.Is(err, errInvalidESICallTimeout) {
+		t.Fatalf("validate() = %v, want %v", err, errInvalidESICallTimeout)
+	}
+}
+
+func TestRuntimeConfigValidateInvalidAppRedirect(t *testing.T) {
+	cfg := validRuntimeConfig()
+	cfg.ESI.AppRedirect = "://not-a-url"
+
+	if err := cfg.validate(); !errors.Is(err, errInvalidAppRedirect) {
+		t.Fatalf("validate() = %v, want %v", err, errInvalidAppRedirect)
+	}
+}
+
+func TestRuntimeConfigValidateAllowsEmptyAppRedirect(t *testing.T) {
+	cfg := validRuntimeConfig()
+	cfg.ESI.AppRedirect = ""
+
+	if err := cfg.validate(); err != nil {
+		t.Fatalf("validate() = %v, want nil", err)
+	}
+}
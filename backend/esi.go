@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+// esiTimeoutCount is registered with the default registry by MetricsModule,
+// not here, so registration happens as part of fx startup rather than
+// package init.
+var esiTimeoutCount = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "esi_call_timeout_total",
+		Help: "Number of outbound ESI calls that exceeded their deadline, labeled by operation.",
+	},
+	[]string{"operation"},
+)
+
+// ESICallTimeout bounds a single outbound ESI call to timeout. operation
+// should be the ESI operation ID (e.g. "get_corporations_corporation_id_blueprints")
+// so a degraded endpoint shows up distinctly in esi_call_timeout_total.
+func ESICallTimeout(ctx context.Context, operation string, timeout time.Duration, call func(context.Context) error) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	err := call(ctx)
+	if ctx.Err() == context.DeadlineExceeded {
+		esiTimeoutCount.WithLabelValues(operation).Inc()
+	}
+	return err
+}
+
+// timeoutWriter passes WriteHeader/Write calls straight through to the
+// underlying writer under a mutex, so deadlineMiddleware can still send its
+// own response if the deadline fires before the handler writes one. Once
+// that has happened, later WriteHeader calls are dropped and Write returns
+// http.ErrHandlerTimeout. Header() is not wrapped.
+type timeoutWriter struct {
+	http.ResponseWriter
+	mu          sync.Mutex
+	timedOut    bool
+	wroteHeader bool
+}
+
+func (tw *timeoutWriter) WriteHeader(code int) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut {
+		return
+	}
+	tw.wroteHeader = true
+	tw.ResponseWriter.WriteHeader(code)
+}
+
+func (tw *timeoutWriter) Write(p []byte) (int, error) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+	tw.wroteHeader = true
+	return tw.ResponseWriter.Write(p)
+}
+
+// deadlineMiddleware derives a context.WithTimeout from
+// cfg.HTTP.RequestTimeout for every inbound request, so a hung downstream
+// call (ESI, the DB) can't pile up goroutines indefinitely. If the deadline
+// fires before the handler has written a response, it writes the upstream
+// timeout error itself.
+func deadlineMiddleware(store *configStore) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			timeout := store.Load().HTTP.RequestTimeout
+			ctx, cancel := context.WithTimeout(r.Context(), timeout)
+			defer cancel()
+
+			tw := &timeoutWriter{ResponseWriter: w}
+
+			done := make(chan struct{})
+			go func() {
+				next.ServeHTTP(tw, r.WithContext(ctx))
+				close(done)
+			}()
+
+			select {
+			case <-done:
+			case <-ctx.Done():
+				tw.mu.Lock()
+				if !tw.wroteHeader {
+					tw.timedOut = true
+					tw.mu.Unlock()
+					httpError(w, "upstream timeout", http.StatusGatewayTimeout)
+				} else {
+					tw.mu.Unlock()
+				}
+			}
+		})
+	}
+}
+
+// cancellableDeadline is a resettable deadline timer modeled on gVisor's
+// netstack cancellable deadline: each call to SetDeadline stops any
+// previously scheduled timer and hands out a brand new cancel channel, so a
+// timer that was already about to fire when SetDeadline is called again can't
+// close the new deadline's channel. It's used by the token refresh loop,
+// where the deadline has to be pushed out every time a new refresh token
+// arrives.
+type cancellableDeadline struct {
+	mu     sync.Mutex
+	timer  *time.Timer
+	cancel chan struct{}
+}
+
+// SetDeadline arranges for the returned channel to be closed after timeout
+// elapses, replacing any deadline set by a previous call.
+func (d *cancellableDeadline) SetDeadline(timeout time.Duration) <-chan struct{} {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.timer != nil {
+		d.timer.Stop()
+	}
+
+	cancel := make(chan struct{})
+	d.cancel = cancel
+	d.timer = time.AfterFunc(timeout, func() {
+		close(cancel)
+	})
+
+	return cancel
+}
+
+// Stop cancels any pending deadline.
+func (d *cancellableDeadline) Stop() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.timer != nil {
+		d.timer.Stop()
+	}
+}
+
+// adminTokenRefreshRetryInterval is how soon runAdminTokenRefreshLoop tries
+// again after a failed refresh, rather than waiting a full JwtSkew.
+const adminTokenRefreshRetryInterval = 30 * time.Second
+
+// runAdminTokenRefreshLoop keeps the admin token fresh for as long as cfg.ESI.JwtSkew
+// allows, using a cancellableDeadline so a successful refresh pushes the next
+// one out instead of leaving a stale timer running. It should be run in its
+// own goroutine and stops when stop is closed.
+func (app *app) runAdminTokenRefreshLoop(stop <-chan struct{}, logger *zap.Logger) {
+	var deadline cancellableDeadline
+	defer deadline.Stop()
+
+	next := deadline.SetDeadline(0)
+	for {
+		select {
+		case <-stop:
+			return
+		case <-next:
+			ctx, cancel := context.WithTimeout(context.Background(), app.config.Load().ESI.CallTimeout)
+			tok := app.getAdminToken(ctx, logger)
+			cancel()
+
+			interval := app.config.Load().ESI.JwtSkew
+			if tok == (scopeSourcePair{}) {
+				logger.Warn("admin token refresh failed, retrying sooner", zap.Duration("retry_in", adminTokenRefreshRetryInterval))
+				interval = adminTokenRefreshRetryInterval
+			}
+			next = deadline.SetDeadline(interval)
+		}
+	}
+}
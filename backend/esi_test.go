@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestESICallTimeoutCountsDeadlineExceeded(t *testing.T) {
+	esiTimeoutCount.Reset()
+
+	err := ESICallTimeout(context.Background(), "test_op", time.Millisecond, func(ctx context.Context) error {
+		<-ctx.Done()
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	got := testutil.ToFloat64(esiTimeoutCount.WithLabelValues("test_op"))
+	if got != 1 {
+		t.Fatalf("esiTimeoutCount[test_op] = %v, want 1", got)
+	}
+}
+
+func TestESICallTimeoutDoesNotCountOnSuccess(t *testing.T) {
+	esiTimeoutCount.Reset()
+
+	wantErr := errors.New("boom")
+	err := ESICallTimeout(context.Background(), "test_op_ok", time.Second, func(context.Context) error {
+		return wantErr
+	})
+	if !errors.Is(err, wantErr) {
+		t.Fatalf("err = %v, want %v", err, wantErr)
+	}
+
+	got := testutil.ToFloat64(esiTimeoutCount.WithLabelValues("test_op_ok"))
+	if got != 0 {
+		t.Fatalf("esiTimeoutCount[test_op_ok] = %v, want 0", got)
+	}
+}
+
+func TestCancellableDeadlineResetDoesNotFireEarly(t *testing.T) {
+	var d cancellableDeadline
+
+	first := d.SetDeadline(10 * time.Millisecond)
+	second := d.SetDeadline(50 * time.Millisecond)
+
+	select {
+	case <-first:
+		t.Fatal("stale deadline channel fired after being replaced")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	select {
+	case <-second:
+	case <-time.After(100 * time.Millisecond):
+		t.Fatal("replacement deadline never fired")
+	}
+}
+
+func TestCancellableDeadlineStop(t *testing.T) {
+	var d cancellableDeadline
+
+	cancel := d.SetDeadline(10 * time.Millisecond)
+	d.Stop()
+
+	select {
+	case <-cancel:
+		t.Fatal("deadline fired after Stop")
+	case <-time.After(30 * time.Millisecond):
+	}
+}
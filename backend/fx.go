@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+
+	"github.com/antihax/goesi"
+	"github.com/bwmarrin/snowflake"
+	_ "github.com/go-sql-driver/mysql"
+	"github.com/gorilla/sessions"
+	"github.com/pressly/goose/v3"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+)
+
+// ConfigModule loads the runtimeConfig behind a *configStore and starts
+// Watch so the config can be hot reloaded, once, up front, so every other
+// module can depend on it.
+var ConfigModule = fx.Module("config",
+	fx.Provide(newConfigStore),
+	fx.Invoke(func(lc fx.Lifecycle, store *configStore, logger *zap.Logger) {
+		stop := make(chan struct{})
+		lc.Append(fx.Hook{
+			OnStart: func(context.Context) error {
+				go store.Watch(stop, logger)
+				return nil
+			},
+			OnStop: func(context.Context) error {
+				close(stop)
+				return nil
+			},
+		})
+	}),
+)
+
+// LoggerModule provides the process-wide zap.Logger, keyed off the
+// runtimeConfig's environment so dev builds get debug-level, human readable
+// output.
+var LoggerModule = fx.Module("logger",
+	fx.Provide(func(store *configStore) *zap.Logger {
+		return newDefaultLogger(store.Load().Log.Environment)
+	}),
+)
+
+// SnowflakeModule provides the snowflake.Node used to mint request IDs and
+// other distributed identifiers.
+var SnowflakeModule = fx.Module("snowflake",
+	fx.Provide(newSnowflake),
+)
+
+// SessionModule provides the gorilla sessions.Store backing user logins and,
+// when it's the MySQL-backed implementation, runs a janitor goroutine that
+// expires old rows.
+var SessionModule = fx.Module("session",
+	fx.Provide(newSessionStore),
+	fx.Invoke(func(lc fx.Lifecycle, store sessions.Store, logger *zap.Logger) {
+		ms, ok := store.(*mysqlStore)
+		if !ok {
+			return
+		}
+
+		stop := make(chan struct{})
+		lc.Append(fx.Hook{
+			OnStart: func(context.Context) error {
+				go ms.runJanitor(stop, logger)
+				return nil
+			},
+			OnStop: func(context.Context) error {
+				close(stop)
+				return nil
+			},
+		})
+	}),
+)
+
+// DBModule opens the MySQL connection pool, runs goose migrations against it
+// on startup (or rolls back the most recent one if MIGRATE_DOWN is set), and
+// appends an fx.Lifecycle hook that closes the pool on shutdown.
+var DBModule = fx.Module("db",
+	fx.Provide(func(lc fx.Lifecycle, store *configStore) (*sql.DB, error) {
+		db, err := sql.Open("mysql", dbConnectString(store.Load()))
+		if err != nil {
+			return nil, err
+		}
+
+		lc.Append(fx.Hook{
+			OnStart: func(context.Context) error {
+				goose.SetBaseFS(embedMigrations)
+				if err := goose.SetDialect("mysql"); err != nil {
+					return err
+				}
+
+				if store.Load().migrateDown != "" {
+					return goose.Down(db, "migrations")
+				}
+				return goose.Up(db, "migrations")
+			},
+			OnStop: func(context.Context) error {
+				return db.Close()
+			},
+		})
+
+		return db, nil
+	}),
+)
+
+// ESIModule provides the shared goesi API client used for every call to the
+// ESI swagger API.
+var ESIModule = fx.Module("esi",
+	fx.Provide(func() *goesi.APIClient {
+		return goesi.NewAPIClient(http.DefaultClient, "brave-bpc")
+	}),
+)
+
+// MetricsModule registers every package-level Prometheus collector with the
+// default registry on startup, so registration happens as part of fx's
+// lifecycle rather than scattered package init funcs.
+var MetricsModule = fx.Module("metrics",
+	fx.Invoke(func(lc fx.Lifecycle) {
+		lc.Append(fx.Hook{
+			OnStart: func(context.Context) error {
+				prometheus.MustRegister(esiTimeoutCount, readOnlyGauge)
+				return nil
+			},
+		})
+	}),
+)
+
+// HealthModule runs the DB/ESI health checker that automatically engages
+// readOnlyMode after repeated failures.
+var HealthModule = fx.Module("health",
+	fx.Invoke(func(lc fx.Lifecycle, db *sql.DB, logger *zap.Logger) {
+		h := newHealthChecker(db, logger)
+		stop := make(chan struct{})
+		lc.Append(fx.Hook{
+			OnStart: func(context.Context) error {
+				go h.run(stop)
+				return nil
+			},
+			OnStop: func(context.Context) error {
+				close(stop)
+				return nil
+			},
+		})
+	}),
+)
+
+// AdminTokenModule keeps the admin character's ESI token fresh in the
+// background so request handlers never block on fetching one.
+var AdminTokenModule = fx.Module("admintoken",
+	fx.Invoke(func(lc fx.Lifecycle, app *app, logger *zap.Logger) {
+		stop := make(chan struct{})
+		lc.Append(fx.Hook{
+			OnStart: func(context.Context) error {
+				go app.runAdminTokenRefreshLoop(stop, logger)
+				return nil
+			},
+			OnStop: func(context.Context) error {
+				close(stop)
+				return nil
+			},
+		})
+	}),
+)
+
+// HTTPModule provides the *http.Server and appends an fx.Lifecycle hook that
+// starts it without blocking fx's OnStart and gives it a chance to drain
+// in-flight requests via Shutdown on OnStop.
+var HTTPModule = fx.Module("http",
+	fx.Provide(func(store *configStore, logger *zap.Logger, flake *snowflake.Node, handler http.Handler) *http.Server {
+		return &http.Server{
+			Addr:    ":" + store.Load().HTTP.Port,
+			Handler: rootHandler(logger, flake, store, handler),
+		}
+	}),
+	fx.Invoke(func(lc fx.Lifecycle, logger *zap.Logger, srv *http.Server) {
+		lc.Append(fx.Hook{
+			OnStart: func(context.Context) error {
+				go func() {
+					if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+						logger.Error("http server stopped unexpectedly", zap.Error(err))
+					}
+				}()
+				return nil
+			},
+			OnStop: func(ctx context.Context) error {
+				return srv.Shutdown(ctx)
+			},
+		})
+	}),
+)
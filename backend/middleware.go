@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/bwmarrin/snowflake"
+	"go.uber.org/zap"
+)
+
+const headerRequestId = "X-Request-Id"
+
+type ctxRequestId struct{}
+
+// requestIdMiddleware mints a snowflake ID for each incoming request, or reuses
+// an inbound X-Request-Id header if one was provided, and echoes it back on the
+// response. It derives a child logger scoped to the request (request_id, method,
+// path, remote addr) and stores both the logger and the ID on the request
+// context for downstream handlers to pick up with getLoggerFromContext and
+// getRequestIdFromContext.
+func requestIdMiddleware(logger *zap.Logger, flake *snowflake.Node) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			id := r.Header.Get(headerRequestId)
+			if id == "" {
+				id = flake.Generate().String()
+			}
+			w.Header().Set(headerRequestId, id)
+
+			reqLogger := logger.With(
+				zap.String("request_id", id),
+				zap.String("method", r.Method),
+				zap.String("path", r.URL.Path),
+				zap.String("remote_addr", r.RemoteAddr),
+			)
+
+			ctx := context.WithValue(r.Context(), ctxLogger{}, reqLogger)
+			ctx = context.WithValue(ctx, ctxRequestId{}, id)
+
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+func getRequestIdFromContext(ctx context.Context) string {
+	if id, ok := ctx.Value(ctxRequestId{}).(string); ok {
+		return id
+	}
+	return ""
+}
+
+// rootHandler wraps handler with the middleware chain shared by every
+// request. requestIdMiddleware goes outermost so every response, including
+// ones short-circuited by a later middleware (deadlineMiddleware's upstream
+// timeout, readOnlyMiddleware's 503), carries a correlation ID in its
+// X-Request-Id header. Those short-circuited responses are not logged.
+func rootHandler(logger *zap.Logger, flake *snowflake.Node, store *configStore, handler http.Handler) http.Handler {
+	return requestIdMiddleware(logger, flake)(
+		deadlineMiddleware(store)(
+			readOnlyMiddleware(handler),
+		),
+	)
+}
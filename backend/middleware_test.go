@@ -0,0 +1,70 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func TestRequestIdMiddlewareGeneratesIdWhenMissing(t *testing.T) {
+	flake := newSnowflake(zap.NewNop())
+
+	var gotId string
+	handler := requestIdMiddleware(zap.NewNop(), flake)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotId = getRequestIdFromContext(r.Context())
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/whatever", nil)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if gotId == "" {
+		t.Fatal("getRequestIdFromContext returned empty id")
+	}
+	if got := rec.Header().Get(headerRequestId); got != gotId {
+		t.Fatalf("response header %s = %q, want %q", headerRequestId, got, gotId)
+	}
+	if getLoggerFromContext(req.Context()) != nil {
+		t.Fatal("logger leaked onto the original request's context")
+	}
+}
+
+func TestRequestIdMiddlewareReusesInboundHeader(t *testing.T) {
+	flake := newSnowflake(zap.NewNop())
+
+	var gotId string
+	handler := requestIdMiddleware(zap.NewNop(), flake)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotId = getRequestIdFromContext(r.Context())
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/whatever", nil)
+	req.Header.Set(headerRequestId, "inbound-id")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if gotId != "inbound-id" {
+		t.Fatalf("getRequestIdFromContext() = %q, want %q", gotId, "inbound-id")
+	}
+	if got := rec.Header().Get(headerRequestId); got != "inbound-id" {
+		t.Fatalf("response header %s = %q, want %q", headerRequestId, got, "inbound-id")
+	}
+}
+
+func TestRequestIdMiddlewareStoresLoggerOnContext(t *testing.T) {
+	flake := newSnowflake(zap.NewNop())
+
+	var gotLogger *zap.Logger
+	handler := requestIdMiddleware(zap.NewNop(), flake)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotLogger = getLoggerFromContext(r.Context())
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/whatever", nil)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if gotLogger == nil {
+		t.Fatal("getLoggerFromContext returned nil")
+	}
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/base32"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+	"go.uber.org/zap"
+)
+
+const sessionIdLength = 32
+
+const (
+	sessionKeyHashLength  = 64
+	sessionKeyBlockLength = 32
+)
+
+// loadOrGenerateSessionKeys resolves the hash/block keys for the MySQL
+// session store. If SESSION_HASH_KEY/SESSION_BLOCK_KEY (base64-encoded) are
+// set, those win; otherwise it reads the keys generated by an earlier
+// instance from the session_keys table, or generates and persists a new pair
+// on first run, so a restart (or scaling out to more replicas) doesn't
+// invalidate every login.
+func loadOrGenerateSessionKeys(db *sql.DB, cfg sessionConfig) (hashKey, blockKey []byte, err error) {
+	if cfg.HashKey != "" && cfg.BlockKey != "" {
+		hashKey, err = base64.StdEncoding.DecodeString(cfg.HashKey)
+		if err != nil {
+			return nil, nil, fmt.Errorf("decoding SESSION_HASH_KEY: %w", err)
+		}
+		blockKey, err = base64.StdEncoding.DecodeString(cfg.BlockKey)
+		if err != nil {
+			return nil, nil, fmt.Errorf("decoding SESSION_BLOCK_KEY: %w", err)
+		}
+		return hashKey, blockKey, nil
+	}
+
+	row := db.QueryRow(`SELECT hash_key, block_key FROM session_keys WHERE id = 1`)
+	err = row.Scan(&hashKey, &blockKey)
+	switch {
+	case err == nil:
+		return hashKey, blockKey, nil
+	case errors.Is(err, sql.ErrNoRows):
+		hashKey = securecookie.GenerateRandomKey(sessionKeyHashLength)
+		blockKey = securecookie.GenerateRandomKey(sessionKeyBlockLength)
+		if _, err := db.Exec(`INSERT IGNORE INTO session_keys (id, hash_key, block_key) VALUES (1, ?, ?)`, hashKey, blockKey); err != nil {
+			return nil, nil, fmt.Errorf("persisting generated session keys: %w", err)
+		}
+
+		// Another replica may have won the race to insert the first row;
+		// re-read whatever ended up authoritative.
+		row := db.QueryRow(`SELECT hash_key, block_key FROM session_keys WHERE id = 1`)
+		if err := row.Scan(&hashKey, &blockKey); err != nil {
+			return nil, nil, fmt.Errorf("loading generated session keys: %w", err)
+		}
+		return hashKey, blockKey, nil
+	default:
+		return nil, nil, fmt.Errorf("loading session keys: %w", err)
+	}
+}
+
+// mysqlStore is a gorilla/sessions.Store backed by a MySQL "sessions" table,
+// so logins survive process restarts and are shared across every instance of
+// the app.
+type mysqlStore struct {
+	db      *sql.DB
+	codecs  []securecookie.Codec
+	options *sessions.Options
+}
+
+// newMySQLSessionStore builds a sessions.Store persisted to MySQL. hashKey and
+// blockKey must be stable across restarts and shared by every instance, or
+// cookies issued by one will fail to decode on another.
+func newMySQLSessionStore(db *sql.DB, hashKey, blockKey []byte) sessions.Store {
+	return &mysqlStore{
+		db:     db,
+		codecs: securecookie.CodecsFromPairs(hashKey, blockKey),
+		options: &sessions.Options{
+			Path:     "/",
+			MaxAge:   86400 * 30,
+			HttpOnly: true,
+		},
+	}
+}
+
+func (s *mysqlStore) Get(r *http.Request, name string) (*sessions.Session, error) {
+	return sessions.GetRegistry(r).Get(s, name)
+}
+
+func (s *mysqlStore) New(r *http.Request, name string) (*sessions.Session, error) {
+	session := sessions.NewSession(s, name)
+	opts := *s.options
+	session.Options = &opts
+	session.IsNew = true
+
+	cookie, err := r.Cookie(name)
+	if err != nil {
+		return session, nil
+	}
+
+	if err := securecookie.DecodeMulti(name, cookie.Value, &session.ID, s.codecs...); err != nil {
+		return session, nil
+	}
+
+	if err := s.load(session); err != nil {
+		return session, nil
+	}
+	session.IsNew = false
+
+	return session, nil
+}
+
+func (s *mysqlStore) Save(r *http.Request, w http.ResponseWriter, session *sessions.Session) error {
+	// MaxAge <= 0 is the standard gorilla/sessions signal to delete the
+	// session (e.g. on logout), so drop the row instead of upserting one with
+	// an already-past expires_at.
+	if session.Options.MaxAge <= 0 {
+		if session.ID != "" {
+			if _, err := s.db.Exec(`DELETE FROM sessions WHERE id = ?`, session.ID); err != nil {
+				return err
+			}
+		}
+		http.SetCookie(w, sessions.NewCookie(session.Name(), "", session.Options))
+		return nil
+	}
+
+	if session.ID == "" {
+		session.ID = strings.TrimRight(base32.StdEncoding.EncodeToString(securecookie.GenerateRandomKey(sessionIdLength)), "=")
+	}
+
+	if err := s.save(session); err != nil {
+		return err
+	}
+
+	encoded, err := securecookie.EncodeMulti(session.Name(), session.ID, s.codecs...)
+	if err != nil {
+		return err
+	}
+
+	http.SetCookie(w, sessions.NewCookie(session.Name(), encoded, session.Options))
+	return nil
+}
+
+func (s *mysqlStore) load(session *sessions.Session) error {
+	var data []byte
+	var expiresAt time.Time
+
+	row := s.db.QueryRow(`SELECT data, expires_at FROM sessions WHERE id = ?`, session.ID)
+	if err := row.Scan(&data, &expiresAt); err != nil {
+		return err
+	}
+
+	if time.Now().After(expiresAt) {
+		return sql.ErrNoRows
+	}
+
+	return securecookie.DecodeMulti(session.Name(), string(data), &session.Values, s.codecs...)
+}
+
+const janitorInterval = 10 * time.Minute
+
+// runJanitor deletes expired session rows every janitorInterval until stop is
+// closed. It should be run in its own goroutine.
+func (s *mysqlStore) runJanitor(stop <-chan struct{}, logger *zap.Logger) {
+	ticker := time.NewTicker(janitorInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			n, err := s.purgeExpired()
+			if err != nil {
+				logger.Error("session janitor failed", zap.Error(err))
+				continue
+			}
+			if n > 0 {
+				logger.Info("session janitor purged expired sessions", zap.Int64("rows", n))
+			}
+		}
+	}
+}
+
+// purgeExpired deletes every session row whose expires_at has passed and
+// returns how many rows were removed.
+func (s *mysqlStore) purgeExpired() (int64, error) {
+	res, err := s.db.Exec(`DELETE FROM sessions WHERE expires_at < ?`, time.Now())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func (s *mysqlStore) save(session *sessions.Session) error {
+	encoded, err := securecookie.EncodeMulti(session.Name(), session.Values, s.codecs...)
+	if err != nil {
+		return err
+	}
+
+	expiresAt := time.Now().Add(time.Duration(session.Options.MaxAge) * time.Second)
+
+	_, err = s.db.Exec(
+		`INSERT INTO sessions (id, data, expires_at) VALUES (?, ?, ?)
+		 ON DUPLICATE KEY UPDATE data = VALUES(data), expires_at = VALUES(expires_at)`,
+		session.ID, encoded, expiresAt,
+	)
+	return err
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+// readOnlyMode gates all non-idempotent HTTP methods behind a single
+// process-wide flag, in the spirit of Harbor's readonly middleware. It is
+// set from the readonly setting (READ_ONLY env var, config file or flag)
+// each time loadConfig runs, at startup and on every Watch reload, which
+// overrides any runtime change. It can be flipped at runtime via POST
+// /admin/readonly, and is engaged automatically by healthChecker after
+// repeated DB/ESI failures.
+var readOnlyMode atomic.Bool
+
+// readOnlyGauge mirrors readOnlyMode for scraping, next to logCount.
+// Registered with the default registry by MetricsModule, not here, so
+// registration happens as part of fx startup rather than package init.
+var readOnlyGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+	Name: "app_read_only",
+	Help: "1 when the app is in read-only/maintenance mode, 0 otherwise.",
+})
+
+// setReadOnly flips readOnlyMode and keeps readOnlyGauge in sync, so every
+// activation path (config, admin endpoint, health checker) goes through one
+// place.
+func setReadOnly(enabled bool) {
+	readOnlyMode.Store(enabled)
+	if enabled {
+		readOnlyGauge.Set(1)
+	} else {
+		readOnlyGauge.Set(0)
+	}
+}
+
+// readOnlyMiddleware short-circuits any request that isn't GET/HEAD/OPTIONS
+// while readOnlyMode is enabled.
+func readOnlyMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if readOnlyMode.Load() {
+			switch r.Method {
+			case http.MethodGet, http.MethodHead, http.MethodOptions:
+			default:
+				httpError(w, "service in read-only mode", http.StatusServiceUnavailable)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+type readOnlyRequest struct {
+	Enabled bool `json:"enabled"`
+}
+
+// handleAdminReadOnly lets the configured admin character flip readOnlyMode
+// at runtime, without a restart.
+func (app *app) handleAdminReadOnly(w http.ResponseWriter, r *http.Request) {
+	adminCharacter := app.config.Load().Admin.Character
+
+	// admin.character being unset (0) must never authorize the request: a
+	// zero-value session (no one logged in) also reports CharacterId 0, so
+	// treating that as a match would fail this endpoint open on any deploy
+	// that hasn't configured an admin character yet.
+	u := app.getUserFromSession(r)
+	if adminCharacter == 0 || u.CharacterId != adminCharacter {
+		httpError(w, "forbidden", http.StatusForbidden)
+		return
+	}
+
+	var req readOnlyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	setReadOnly(req.Enabled)
+	httpWrite(w, readOnlyRequest{Enabled: readOnlyMode.Load()})
+}
+
+// healthChecker periodically pings the DB and ESI and automatically engages
+// readOnlyMode after failureThreshold consecutive failures of either check,
+// so the app protects itself during an ESI outage or DB failover without an
+// operator needing to notice and flip the flag by hand.
+type healthChecker struct {
+	db               *sql.DB
+	logger           *zap.Logger
+	interval         time.Duration
+	failureThreshold int
+
+	consecutiveFailures int
+}
+
+// newHealthChecker builds a healthChecker with sane defaults for polling
+// interval and failure threshold.
+func newHealthChecker(db *sql.DB, logger *zap.Logger) *healthChecker {
+	return &healthChecker{
+		db:               db,
+		logger:           logger,
+		interval:         15 * time.Second,
+		failureThreshold: 3,
+	}
+}
+
+// run polls on h.interval until stop is closed. It should be run in its own
+// goroutine.
+func (h *healthChecker) run(stop <-chan struct{}) {
+	ticker := time.NewTicker(h.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			h.check()
+		}
+	}
+}
+
+func (h *healthChecker) check() {
+	if err := h.healthy(); err == nil {
+		h.consecutiveFailures = 0
+		return
+	} else {
+		h.consecutiveFailures++
+		h.logger.Warn("health check failed", zap.Error(err), zap.Int("consecutive_failures", h.consecutiveFailures))
+	}
+
+	if h.consecutiveFailures >= h.failureThreshold && !readOnlyMode.Load() {
+		h.logger.Warn("health check failed repeatedly, engaging read-only mode",
+			zap.Int("consecutive_failures", h.consecutiveFailures))
+		setReadOnly(true)
+	}
+}
+
+func (h *healthChecker) healthy() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := h.db.PingContext(ctx); err != nil {
+		return fmt.Errorf("db ping: %w", err)
+	}
+
+	if err := checkEsiStatus(ctx); err != nil {
+		return fmt.Errorf("esi status: %w", err)
+	}
+
+	return nil
+}
+
+// checkEsiStatus is a minimal liveness check against ESI's own status
+// endpoint, independent of any particular scope or character token.
+func checkEsiStatus(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "https://esi.evetech.net/latest/status/", nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return fmt.Errorf("esi status returned %d", resp.StatusCode)
+	}
+
+	return nil
+}
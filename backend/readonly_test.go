@@ -0,0 +1,68 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestReadOnlyMiddlewareBlocksMutatingMethodsWhenEnabled(t *testing.T) {
+	setReadOnly(true)
+	defer setReadOnly(false)
+
+	called := false
+	handler := readOnlyMiddleware(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+		called = true
+	}))
+
+	req := httptest.NewRequest(http.MethodPost, "/whatever", nil)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if called {
+		t.Fatal("handler ran despite read-only mode")
+	}
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusServiceUnavailable)
+	}
+}
+
+func TestReadOnlyMiddlewareAllowsSafeMethodsWhenEnabled(t *testing.T) {
+	setReadOnly(true)
+	defer setReadOnly(false)
+
+	for _, method := range []string{http.MethodGet, http.MethodHead, http.MethodOptions} {
+		called := false
+		handler := readOnlyMiddleware(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+			called = true
+		}))
+
+		req := httptest.NewRequest(method, "/whatever", nil)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if !called {
+			t.Fatalf("%s: handler did not run while read-only mode was enabled", method)
+		}
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: status = %d, want %d", method, rec.Code, http.StatusOK)
+		}
+	}
+}
+
+func TestReadOnlyMiddlewarePassesThroughWhenDisabled(t *testing.T) {
+	setReadOnly(false)
+
+	called := false
+	handler := readOnlyMiddleware(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+		called = true
+	}))
+
+	req := httptest.NewRequest(http.MethodPost, "/whatever", nil)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if !called {
+		t.Fatal("handler did not run while read-only mode was disabled")
+	}
+}
@@ -0,0 +1,19 @@
+package main
+
+import (
+	"net/http"
+
+	"go.uber.org/fx"
+)
+
+// RoutesModule assembles the process's http.Handler. Handlers register
+// themselves here as the HTTP surface grows.
+var RoutesModule = fx.Module("routes",
+	fx.Provide(newRouter),
+)
+
+func newRouter(app *app) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /admin/readonly", app.handleAdminReadOnly)
+	return mux
+}
@@ -2,11 +2,10 @@ package main
 
 import (
 	"context"
+	"database/sql"
 	"embed"
 	"encoding/json"
-	"errors"
 	"fmt"
-	"io/fs"
 	"net/http"
 	"os"
 	"time"
@@ -16,7 +15,6 @@ import (
 	"github.com/bwmarrin/snowflake"
 	"github.com/gorilla/securecookie"
 	"github.com/gorilla/sessions"
-	"github.com/hashicorp/go-envparse"
 	zaplogfmt "github.com/sykesm/zap-logfmt"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
@@ -35,11 +33,28 @@ const (
 	envDbHost      = "DB_HOST"
 	envDbPort      = "DB_PORT"
 	envDbName      = "DB_NAME"
+	envReadOnly    = "READ_ONLY"
+
+	envHttpRequestTimeout = "HTTP_REQUEST_TIMEOUT"
+	envEsiCallTimeout     = "ESI_CALL_TIMEOUT"
+
+	envSessionStore    = "SESSION_STORE"
+	envSessionHashKey  = "SESSION_HASH_KEY"
+	envSessionBlockKey = "SESSION_BLOCK_KEY"
 
 	cookieSession = "brave-bpc-session"
 	cookieUser    = "brave-bpc"
 )
 
+// sessionStoreKind selects which sessions.Store implementation newSessionStore
+// builds.
+type sessionStoreKind string
+
+const (
+	sessionStoreFilesystem sessionStoreKind = "filesystem"
+	sessionStoreMySQL      sessionStoreKind = "mysql"
+)
+
 func newDefaultLogger(env string) *zap.Logger {
 	cfg := zap.NewProductionEncoderConfig()
 	cfg.EncodeTime = zapcore.ISO8601TimeEncoder
@@ -82,88 +97,42 @@ func newSnowflake(logger *zap.Logger) *snowflake.Node {
 	return flake
 }
 
-func newSessionStore() sessions.Store {
-	return sessions.NewFilesystemStore(
-		os.TempDir(),
-		securecookie.GenerateRandomKey(64),
-		securecookie.GenerateRandomKey(32),
-	)
-}
-
-func dbConnectString() string {
-	user := getEnvWithDefault(envDbUser, "local")
-	pass := getEnvWithDefault(envDbPass, "local")
-	host := getEnvWithDefault(envDbHost, "localhost")
-	port := getEnvWithDefault(envDbPort, "3308")
-	name := getEnvWithDefault(envDbName, "local")
-
-	if host[0] == '/' {
-		return fmt.Sprintf("%s:%s@unix(%s)/%s", user, pass, host, name)
+// newSessionStore builds the sessions.Store used for user logins. The
+// filesystem store is the default for local development; set
+// SESSION_STORE=mysql to persist sessions in the database instead, so a
+// restart or running multiple instances doesn't invalidate everyone's login.
+// If SESSION_HASH_KEY/SESSION_BLOCK_KEY aren't set, keys are generated on
+// first run and persisted to the session_keys table so every replica
+// converges on the same ones.
+func newSessionStore(store *configStore, db *sql.DB) (sessions.Store, error) {
+	cfg := store.Load()
+	if cfg.Session.Store != sessionStoreMySQL {
+		return sessions.NewFilesystemStore(
+			os.TempDir(),
+			securecookie.GenerateRandomKey(64),
+			securecookie.GenerateRandomKey(32),
+		), nil
 	}
 
-	return fmt.Sprintf("%s:%s@tcp(%s:%s)/%s", user, pass, host, port, name)
-}
-
-//go:embed migrations/*.sql
-var embedMigrations embed.FS
-
-var errEnvFile = errors.New("failed to load .env file")
-
-// loadEnv parses the contents of .env and sets any unset environment variables
-func loadEnv() (*runtimeConfig, error) {
-	fp, err := os.Open("./.env")
-	if err != nil {
-		if errors.Is(err, fs.ErrNotExist) {
-			return nil, err
-		}
-		return nil, errors.Join(errEnvFile, err)
-	}
-	defer fp.Close()
-
-	env, err := envparse.Parse(fp)
+	hashKey, blockKey, err := loadOrGenerateSessionKeys(db, cfg.Session)
 	if err != nil {
 		return nil, err
 	}
 
-	for k, v := range env {
-		setUnsetEnv(k, v)
-	}
-
-	skewStr := getEnvWithDefault(envJwtSkew, "5m")
-	skew, err := time.ParseDuration(skewStr)
-	if err != nil {
-		skew = time.Second
-		return nil, fmt.Errorf("error parsing jwt skew env var: %w", err)
-	}
-
-	return &runtimeConfig{
-		appId:       os.Getenv(envAppId),
-		appSecret:   os.Getenv(envAppSecret),
-		appRedirect: os.Getenv(envAppRedirect),
-		environment: os.Getenv(envEnvironment),
-		migrateDown: os.Getenv(envMigrateDown),
-		httpPort:    getEnvWithDefault(envHttpPort, "2727"),
-		jwtSkew:     skew,
-	}, nil
+	return newMySQLSessionStore(db, hashKey, blockKey), nil
 }
 
-// checks if an environment variable has been set.
-// if it hasn't, set it with the value param
-// returns the set value
-func setUnsetEnv(key string, value string) {
-	if _, set := os.LookupEnv(key); !set {
-		os.Setenv(key, value)
+func dbConnectString(cfg *runtimeConfig) string {
+	if cfg.DB.Host[0] == '/' {
+		return fmt.Sprintf("%s:%s@unix(%s)/%s", cfg.DB.User, cfg.DB.Pass, cfg.DB.Host, cfg.DB.Name)
 	}
-}
 
-func getEnvWithDefault(key string, value string) string {
-	val, set := os.LookupEnv(key)
-	if set {
-		return val
-	}
-	return value
+	return fmt.Sprintf("%s:%s@tcp(%s:%s)/%s", cfg.DB.User, cfg.DB.Pass, cfg.DB.Host, cfg.DB.Port, cfg.DB.Name)
 }
 
+//go:embed migrations/*.sql
+var embedMigrations embed.FS
+
 func getLoggerFromContext(ctx context.Context) *zap.Logger {
 	if logger, ok := ctx.Value(ctxLogger{}).(*zap.Logger); ok {
 		return logger
@@ -199,16 +168,31 @@ func httpWrite(w http.ResponseWriter, data any) {
 	w.Write(buf)
 }
 
-func (app *app) getAdminToken(logger *zap.Logger) scopeSourcePair {
-	tsps := app.dao.getTokenForCharacter(logger, app.config.AdminCharacter, []string{
-		string(glue.EsiScope_AssetsReadCorporationAssets_v1),
-		string(glue.EsiScope_CorporationsReadBlueprints_v1),
-		string(glue.EsiScope_CorporationsReadDivisions_v1),
-		string(glue.EsiScope_IndustryReadCorporationJobs_v1),
-		string(glue.EsiScope_UniverseReadStructures_v1),
+func (app *app) getAdminToken(ctx context.Context, logger *zap.Logger) scopeSourcePair {
+	cfg := app.config.Load()
+
+	var tsps []scopeSourcePair
+	err := ESICallTimeout(ctx, "get_admin_token", cfg.ESI.CallTimeout, func(ctx context.Context) error {
+		tsps = app.dao.getTokenForCharacter(ctx, logger, cfg.Admin.Character, []string{
+			string(glue.EsiScope_AssetsReadCorporationAssets_v1),
+			string(glue.EsiScope_CorporationsReadBlueprints_v1),
+			string(glue.EsiScope_CorporationsReadDivisions_v1),
+			string(glue.EsiScope_IndustryReadCorporationJobs_v1),
+			string(glue.EsiScope_UniverseReadStructures_v1),
+		})
+		return nil
+	})
+	if err != nil {
+		logger.Error("failed to fetch admin token", zap.Error(err))
+		return scopeSourcePair{}
+	}
+
+	var toks []scopeSourcePair
+	err = ESICallTimeout(ctx, "create_admin_tokens", cfg.ESI.CallTimeout, func(ctx context.Context) error {
+		toks = app.createTokens(ctx, tsps)
+		return nil
 	})
-	toks := app.createTokens(tsps)
-	if len(toks) == 0 {
+	if err != nil || len(toks) == 0 {
 		return scopeSourcePair{}
 	}
 	return toks[0]
@@ -0,0 +1,34 @@
+package main
+
+import "testing"
+
+func TestDbConnectStringTCP(t *testing.T) {
+	cfg := &runtimeConfig{DB: dbConfig{
+		User: "local",
+		Pass: "local",
+		Host: "localhost",
+		Port: "3308",
+		Name: "local",
+	}}
+
+	got := dbConnectString(cfg)
+	want := "local:local@tcp(localhost:3308)/local"
+	if got != want {
+		t.Fatalf("dbConnectString() = %q, want %q", got, want)
+	}
+}
+
+func TestDbConnectStringUnixSocket(t *testing.T) {
+	cfg := &runtimeConfig{DB: dbConfig{
+		User: "local",
+		Pass: "local",
+		Host: "/var/run/mysqld/mysqld.sock",
+		Name: "local",
+	}}
+
+	got := dbConnectString(cfg)
+	want := "local:local@unix(/var/run/mysqld/mysqld.sock)/local"
+	if got != want {
+		t.Fatalf("dbConnectString() = %q, want %q", got, want)
+	}
+}